@@ -0,0 +1,168 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// diffContextLine is one line buffered while looking for the requested line
+// number, tagged with the left/right indices it resolves to once parsed.
+type diffContextLine struct {
+	text              string
+	leftIdx, rightIdx int64
+}
+
+// CutDiffAroundLine reads a unified diff from in and returns a synthesized
+// mini-diff containing only the hunk around the requested line, with a
+// fresh `@@` header and numbersOfLine lines of context above and below. When
+// old is true, line is a left-side (pre-image) line number; otherwise it is
+// a right-side (post-image) line number.
+//
+// It is implemented as a streaming, single-pass parser over in (piped
+// through an io.Pipe) rather than buffering the whole diff, since diffs fed
+// to this function can be arbitrarily large.
+func CutDiffAroundLine(in io.Reader, line int64, old bool, numbersOfLine int) (string, error) {
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(scanDiffAroundLine(in, pw, line, old, numbersOfLine))
+	}()
+
+	out, err := io.ReadAll(pr)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func scanDiffAroundLine(in io.Reader, w io.Writer, line int64, old bool, numbersOfLine int) error {
+	reader := bufio.NewReader(in)
+
+	var inHunk bool
+	var leftIdx, rightIdx int64
+	var buf []diffContextLine
+	var matched bool
+	var trailing int
+
+	resetHunk := func() {
+		inHunk = false
+		buf = nil
+		matched = false
+	}
+
+	for {
+		raw, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if raw == "" && readErr == io.EOF {
+			break
+		}
+		text := strings.TrimRight(raw, "\r\n")
+
+		switch {
+		case strings.HasPrefix(text, "diff --git"):
+			if matched {
+				return writeDiffContext(w, buf)
+			}
+			resetHunk()
+
+		case strings.HasPrefix(text, "@@"):
+			if matched {
+				return writeDiffContext(w, buf)
+			}
+			l, _, r, _ := ParseDiffHunkString(text)
+			leftIdx, rightIdx = int64(l), int64(r)
+			inHunk = true
+			buf = nil
+
+		// A line starting with "---"/"+++" that is NOT a file header (i.e. we
+		// are already inside a hunk) is actual hunk content - e.g. a markdown
+		// separator being added/removed - and must fall through to the
+		// regular content handling below, not be mistaken for a header.
+		case inHunk:
+			// Once we've already captured numbersOfLine trailing lines after
+			// the match, stop before buffering another one.
+			if matched && trailing == 0 {
+				return writeDiffContext(w, buf)
+			}
+
+			cur := diffContextLine{text: text}
+			isAdd := strings.HasPrefix(text, "+")
+			isDel := strings.HasPrefix(text, "-")
+			switch {
+			case isAdd:
+				cur.rightIdx = rightIdx
+				rightIdx++
+			case isDel:
+				cur.leftIdx = leftIdx
+				leftIdx++
+			default:
+				cur.leftIdx, cur.rightIdx = leftIdx, rightIdx
+				leftIdx++
+				rightIdx++
+			}
+
+			buf = append(buf, cur)
+
+			if !matched {
+				if (old && !isAdd && cur.leftIdx == line) || (!old && !isDel && cur.rightIdx == line) {
+					matched = true
+					trailing = numbersOfLine
+				} else if len(buf) > numbersOfLine {
+					buf = buf[len(buf)-numbersOfLine:]
+				}
+			} else {
+				trailing--
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	if matched {
+		return writeDiffContext(w, buf)
+	}
+	return fmt.Errorf("line %d not found in diff", line)
+}
+
+// writeDiffContext synthesizes a fresh `@@` header spanning buf and writes
+// the hunk header followed by the buffered lines.
+func writeDiffContext(w io.Writer, buf []diffContextLine) error {
+	if len(buf) == 0 {
+		return fmt.Errorf("no lines to write")
+	}
+
+	var leftStart, rightStart int64
+	var leftCount, rightCount int
+	for _, l := range buf {
+		isAdd := strings.HasPrefix(l.text, "+")
+		isDel := strings.HasPrefix(l.text, "-")
+		if !isAdd {
+			if leftStart == 0 {
+				leftStart = l.leftIdx
+			}
+			leftCount++
+		}
+		if !isDel {
+			if rightStart == 0 {
+				rightStart = l.rightIdx
+			}
+			rightCount++
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", leftStart, leftCount, rightStart, rightCount); err != nil {
+		return err
+	}
+	for _, l := range buf {
+		if _, err := fmt.Fprintln(w, l.text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
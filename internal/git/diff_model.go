@@ -0,0 +1,54 @@
+package git
+
+// DiffLineType describes the role a DiffLine plays inside its DiffSection.
+// There is no Section variant: the hunk header lives on DiffSection.HunkHeader
+// rather than as a DiffLine, since it isn't content on either side.
+type DiffLineType int
+
+const (
+	DiffLinePlain DiffLineType = iota
+	DiffLineAdd
+	DiffLineDel
+)
+
+// DiffMatchType describes a single intra-line word-diff chunk, mirroring
+// diffmatchpatch's own operation type.
+type DiffMatchType int
+
+const (
+	DiffMatchEqual DiffMatchType = iota
+	DiffMatchInsert
+	DiffMatchDelete
+)
+
+// DiffMatch is one chunk of an intra-line word diff between a Del line and
+// the Add line paired with it.
+type DiffMatch struct {
+	Type DiffMatchType
+	Text string
+}
+
+// DiffLine is a single line of a hunk, with its left/right line numbers
+// already resolved so the renderer does not need to recompute them.
+type DiffLine struct {
+	LeftIdx   int
+	RightIdx  int
+	Type      DiffLineType
+	Content   string
+	Truncated bool
+
+	// LeftDiff/RightDiff are only set on a paired Del/Add, and annotate the
+	// intra-line word diff between them. Del lines are queued until a
+	// context line or a new hunk, and each Add line is paired with the
+	// oldest queued Del, so in a block of Dels followed by Adds the n-th Add
+	// is paired with the n-th Del.
+	LeftDiff  []DiffMatch
+	RightDiff []DiffMatch
+}
+
+// DiffSection is one hunk of a file diff: its `@@ -a,b +c,d @@` header plus
+// the lines it contains.
+type DiffSection struct {
+	HunkHeader string
+	Lines      []*DiffLine
+}
@@ -0,0 +1,55 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// StreamLog runs `git log` with the given args against repo and streams the
+// parsed commits on the returned channel as soon as each one is complete.
+// Cancelling ctx stops the pipeline: the git subprocess is killed and
+// reaped, and the io.Pipe is closed, before this function's goroutine exits.
+// A caller that only reads the first N commits and then cancels ctx does
+// not pay for parsing the rest of the history.
+func StreamLog(ctx context.Context, repo *Repository, args []string, opts ParseOptions) (<-chan *Commit, <-chan error) {
+	commits := make(chan *Commit)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(commits)
+		defer close(errCh)
+
+		pr, pw := io.Pipe()
+		g, gctx := errgroup.WithContext(ctx)
+
+		g.Go(func() error {
+			cmd := repo.gitCommand(gctx, args...)
+			cmd.Stdout = pw
+			stderr := &bytes.Buffer{}
+			cmd.Stderr = stderr
+
+			runErr := cmd.Run()
+			closeErr := pw.CloseWithError(runErr)
+			if runErr != nil {
+				return fmt.Errorf("git log: %w: %s", runErr, strings.TrimSpace(stderr.String()))
+			}
+			return closeErr
+		})
+
+		g.Go(func() error {
+			defer pr.Close()
+			return parseLog(gctx, pr, opts, commits)
+		})
+
+		if err := g.Wait(); err != nil {
+			errCh <- err
+		}
+	}()
+
+	return commits, errCh
+}
@@ -0,0 +1,199 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxNameStatusPaths bounds how many paths we pass on the command line
+// before switching to client-side filtering, since a long pathspec can blow
+// past ARG_MAX.
+const maxNameStatusPaths = 70
+
+// NameStatusCommit is one commit's worth of `git log --name-status` output.
+type NameStatusCommit struct {
+	CommitID  string
+	ParentIDs []string
+	Modified  []string
+	Added     []string
+	Removed   []string
+	Renamed   []string
+}
+
+// LogNameStatusRepo spawns `git log --name-status` scoped to treepath (or to
+// paths, when there are few enough to pass safely on the command line) and
+// returns a reader over its NUL-delimited output, plus a cancel func that
+// tears down the subprocess. It skips diff content entirely, so it is far
+// cheaper than the `-p`-based parser for "which commits touched this path"
+// queries. Read records off the returned *bufio.Reader with NextNameStatus.
+func LogNameStatusRepo(ctx context.Context, repoPath, head, treepath string, paths ...string) (*bufio.Reader, func()) {
+	args := []string{
+		"log", "--name-status", "-c",
+		"--format=commit%x00%H %P%x00",
+		"--parents", "-t", "-z",
+		head,
+	}
+
+	switch {
+	case treepath != "":
+		args = append(args, "--", treepath)
+	case len(paths) > 0 && len(paths) <= maxNameStatusPaths:
+		args = append(args, "--")
+		args = append(args, paths...)
+	}
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = repoPath
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Error().Err(err).Msg("git log --name-status: failed to open stdout pipe")
+		return bufio.NewReader(strings.NewReader("")), func() {}
+	}
+	if err := cmd.Start(); err != nil {
+		log.Error().Err(err).Msg("git log --name-status: failed to start")
+		return bufio.NewReader(strings.NewReader("")), func() {}
+	}
+
+	cancel := func() {
+		if cmd.Process != nil {
+			_ = cmd.Process.Kill()
+		}
+		_ = cmd.Wait()
+	}
+
+	return bufio.NewReader(stdout), cancel
+}
+
+// NextNameStatus reads one commit's record off r. When len(paths) > 0 and
+// the record's lines were not already scoped server-side (see
+// maxNameStatusPaths above), only entries touching one of paths are kept.
+// It returns io.EOF once the stream is exhausted.
+func NextNameStatus(r *bufio.Reader, paths ...string) (*NameStatusCommit, error) {
+	tok, err := readNulToken(r)
+	if err != nil {
+		return nil, err
+	}
+	if tok != "commit" {
+		return nil, fmt.Errorf("name-status: expected %q, got %q", "commit", tok)
+	}
+
+	header, err := readNulToken(r)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("name-status: malformed commit header %q", header)
+	}
+	commit := &NameStatusCommit{CommitID: fields[0], ParentIDs: fields[1:]}
+
+	included := func(path string) bool {
+		if len(paths) == 0 {
+			return true
+		}
+		for _, want := range paths {
+			if want == path {
+				return true
+			}
+		}
+		return false
+	}
+
+	for {
+		peeked, err := peekNulToken(r)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err == io.EOF {
+			break
+		}
+		if peeked == "commit" {
+			break
+		}
+		if peeked == "" {
+			// The trailing %x00 in --format and -z's own record separator
+			// both fire between a commit's header and its first entry (and
+			// between a commit with no entries and the next "commit"
+			// marker), producing a stray empty NUL token. Consume and skip
+			// it rather than treating it as end-of-record.
+			if _, err := readNulToken(r); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		status, err := readNulToken(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(status, "R") || strings.HasPrefix(status, "C") {
+			oldPath, err := readNulToken(r)
+			if err != nil {
+				return nil, err
+			}
+			newPath, err := readNulToken(r)
+			if err != nil {
+				return nil, err
+			}
+			if included(oldPath) || included(newPath) {
+				commit.Renamed = append(commit.Renamed, oldPath+" -> "+newPath)
+			}
+			continue
+		}
+
+		// With -z, the status code and path are separate NUL tokens, not a
+		// single tab-joined token.
+		path, err := readNulToken(r)
+		if err != nil {
+			return nil, err
+		}
+		if !included(path) {
+			continue
+		}
+
+		switch status[0] {
+		case 'M':
+			commit.Modified = append(commit.Modified, path)
+		case 'A':
+			commit.Added = append(commit.Added, path)
+		case 'D':
+			commit.Removed = append(commit.Removed, path)
+		}
+	}
+
+	return commit, nil
+}
+
+// readNulToken reads and consumes one NUL-delimited token.
+func readNulToken(r *bufio.Reader) (string, error) {
+	s, err := r.ReadString(0)
+	if err != nil {
+		return "", err
+	}
+	return s[:len(s)-1], nil
+}
+
+// peekNulToken returns the next NUL-delimited token without consuming it.
+func peekNulToken(r *bufio.Reader) (string, error) {
+	for n := 64; ; n *= 2 {
+		buf, err := r.Peek(n)
+		if idx := bytes.IndexByte(buf, 0); idx >= 0 {
+			return string(buf[:idx]), nil
+		}
+		if err != nil {
+			if err == io.EOF {
+				return string(buf), io.EOF
+			}
+			return "", err
+		}
+	}
+}
@@ -3,24 +3,42 @@ package git
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/csv"
 	"fmt"
 	"github.com/rs/zerolog/log"
+	"github.com/sergi/go-diff/diffmatchpatch"
 	"io"
-	"regexp"
 	"strconv"
 	"strings"
 )
 
+// maxIntralineDiffBytes caps the size of the lines fed to diffmatchpatch for
+// intra-line word diffs, to avoid pathological O(n*m) blowups on huge lines.
+const maxIntralineDiffBytes = 2000
+
+// defaultLogReadBufferSize is the bufio.Reader buffer size used while
+// reading `git log` output; it is unrelated to ParseOptions.MaxLinesPerFile.
+const defaultLogReadBufferSize = 64 * 1024
+
 type File struct {
 	Filename    string `json:"filename"`
 	Size        uint64 `json:"size"`
 	HumanSize   string `json:"human_size"`
 	OldFilename string `json:"-"`
 	Content     string `json:"content"`
-	Truncated   bool   `json:"truncated"`
-	IsCreated   bool   `json:"-"`
-	IsDeleted   bool   `json:"-"`
+	// Truncated means the whole rest of this file's diff was skipped once
+	// MaxLinesPerFile was hit. HasTruncatedLine means at least one kept
+	// line was shortened, to MaxCharsPerLine or to the 64 KiB read buffer
+	// size, whichever is smaller; both flags can be set on the same file.
+	Truncated        bool `json:"truncated"`
+	HasTruncatedLine bool `json:"has_truncated_line"`
+	IsCreated        bool `json:"-"`
+	IsDeleted        bool `json:"-"`
+
+	// Sections holds the structured hunk/line tree for this file's diff.
+	// Content is kept in parallel for backwards compatibility.
+	Sections []*DiffSection `json:"-"`
 }
 
 type CsvFile struct {
@@ -68,22 +86,41 @@ func truncateCommandOutput(out io.Reader, maxBytes int64) (string, bool, error)
 // todo:
 // - shortstat
 // - disable empty commit (in git counts log etc)
-// - lines max/bytes max by line
-func parseLog(out io.Reader, maxFiles int, maxBytes int) ([]*Commit, error) {
-	var commits []*Commit
+//
+// parseLog reads `git log` output from out and sends each parsed *Commit on
+// commits as soon as it is complete, so a caller can stop early without
+// waiting for the whole history. It checks ctx between commits and while
+// blocked sending, so StreamLog's cancellation reaches all the way here.
+func parseLog(ctx context.Context, out io.Reader, opts ParseOptions, commits chan<- *Commit) error {
 	var currentCommit *Commit
 	var headerParsed = false
-	input := bufio.NewReaderSize(out, maxBytes)
+	input := bufio.NewReaderSize(out, defaultLogReadBufferSize)
+
+	emit := func() error {
+		if currentCommit == nil {
+			return nil
+		}
+		select {
+		case commits <- currentCommit:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 
 	// Loop Commits
 loopCommits:
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		line, err := input.ReadString('\n')
 		if err != nil {
 			if err == io.EOF {
 				break loopCommits
 			}
-			return commits, err
+			return err
 		}
 		if len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r') {
 			line = line[:len(line)-1]
@@ -94,7 +131,9 @@ loopCommits:
 		// Commit hash
 		case 'c':
 			if headerParsed {
-				commits = append(commits, currentCommit)
+				if err := emit(); err != nil {
+					return err
+				}
 			}
 			currentCommit = &Commit{Hash: line[2:], Files: []File{}}
 			continue
@@ -122,7 +161,7 @@ loopCommits:
 		loopDiff:
 			for {
 
-				if maxFiles > -1 && len(currentCommit.Files) >= maxFiles {
+				if opts.MaxFiles > -1 && len(currentCommit.Files) >= opts.MaxFiles {
 					_, _ = io.Copy(io.Discard, input)
 					headerParsed = false
 					break loopDiff
@@ -135,7 +174,7 @@ loopCommits:
 					line, err = input.ReadString('\n')
 					if err != nil {
 						if err != io.EOF {
-							return commits, err
+							return err
 						}
 						headerParsed = false
 						break loopDiff
@@ -184,10 +223,10 @@ loopCommits:
 
 						// header is finally parsed
 
-						lineBytes, isFragment, err := parseHunks(currentFile, maxBytes, input)
+						lineBytes, isFragment, err := parseHunks(currentFile, opts, input)
 						if err != nil {
 							if err != io.EOF {
-								return commits, err
+								return err
 							}
 							// EOF, we are done with this file
 							currentCommit.Files = append(currentCommit.Files, *currentFile)
@@ -198,7 +237,6 @@ loopCommits:
 						sb.Reset()
 						_, _ = sb.Write(lineBytes)
 
-						fmt.Print("linebytes#" + string(lineBytes) + "#\n")
 						if string(lineBytes) == "" {
 							headerParsed = false
 							break loopDiff
@@ -208,7 +246,7 @@ loopCommits:
 							lineBytes, isFragment, err = input.ReadLine()
 							if err != nil {
 								// Now by the definition of ReadLine this cannot be io.EOF
-								return commits, fmt.Errorf("unable to ReadLine: %w", err)
+								return fmt.Errorf("unable to ReadLine: %w", err)
 							}
 							_, _ = sb.Write(lineBytes)
 
@@ -221,19 +259,33 @@ loopCommits:
 				}
 			}
 		}
-		commits = append(commits, currentCommit)
+		if err := emit(); err != nil {
+			return err
+		}
 	}
 
-	return commits, nil
+	return nil
 }
 
-func parseHunks(currentFile *File, maxBytes int, input *bufio.Reader) (lineBytes []byte, isFragment bool, err error) {
-	sb := &strings.Builder{}
+func parseHunks(currentFile *File, opts ParseOptions, input *bufio.Reader) (lineBytes []byte, isFragment bool, err error) {
 	var currFileLineCount int
+	var currentSection *DiffSection
+	var leftIdx, rightIdx int
+	// pendingDels queues consecutive Del lines so each is word-diffed
+	// against its positional Add, not just the most recent Del against the
+	// first Add of a multi-line edit block.
+	var pendingDels []*DiffLine
+
+	flushSection := func() {
+		if currentSection != nil {
+			currentFile.Sections = append(currentFile.Sections, currentSection)
+		}
+	}
 
 	for {
 		for isFragment {
-			currentFile.Truncated = true
+			// Drain the rest of an overlong line that was skipped once
+			// MaxLinesPerFile was hit; File.Truncated already covers it.
 
 			// Read the next line
 			_, isFragment, err = input.ReadLine()
@@ -242,33 +294,38 @@ func parseHunks(currentFile *File, maxBytes int, input *bufio.Reader) (lineBytes
 			}
 		}
 
-		sb.Reset()
-
 		// Read the next line
 		lineBytes, isFragment, err = input.ReadLine()
 		if err != nil {
 			if err == io.EOF {
+				flushSection()
 				return lineBytes, false, err
 			}
 			return nil, false, err
 		}
 
 		if len(lineBytes) == 0 {
+			flushSection()
 			return lineBytes, false, err
 		}
 		if lineBytes[0] == 'd' {
 			// End of hunks
+			flushSection()
 			return lineBytes, isFragment, err
 		}
 
-		if maxBytes > -1 && currFileLineCount >= maxBytes {
+		if opts.MaxLinesPerFile > -1 && currFileLineCount >= opts.MaxLinesPerFile {
 			currentFile.Truncated = true
 			continue
 		}
 
 		line := string(lineBytes)
+		lineTruncated := false
 		if isFragment {
-			currentFile.Truncated = true
+			// This single line is longer than the read buffer; that's a
+			// per-line truncation, not a whole-file one.
+			currentFile.HasTruncatedLine = true
+			lineTruncated = true
 			for isFragment {
 				lineBytes, isFragment, err = input.ReadLine()
 				if err != nil {
@@ -277,12 +334,103 @@ func parseHunks(currentFile *File, maxBytes int, input *bufio.Reader) (lineBytes
 				}
 			}
 		}
-		if false {
-			//if len(line) > maxBytes {
-			currentFile.Truncated = true
-			line = line[:maxBytes]
+
+		if opts.MaxCharsPerLine > 0 && len(line) > opts.MaxCharsPerLine {
+			line = line[:opts.MaxCharsPerLine]
+			lineTruncated = true
+			currentFile.HasTruncatedLine = true
 		}
+
+		currFileLineCount++
 		currentFile.Content += line + "\n"
+
+		if len(line) > 0 && line[0] == '@' {
+			flushSection()
+			l, _, r, _ := ParseDiffHunkString(line)
+			leftIdx, rightIdx = l, r
+			currentSection = &DiffSection{HunkHeader: line}
+			pendingDels = nil
+			continue
+		}
+
+		if currentSection == nil {
+			// Content outside of any hunk (shouldn't normally happen).
+			continue
+		}
+
+		dl := &DiffLine{Content: line, Truncated: lineTruncated}
+		switch {
+		case len(line) == 0:
+			dl.Type = DiffLinePlain
+			dl.LeftIdx, dl.RightIdx = leftIdx, rightIdx
+			leftIdx++
+			rightIdx++
+			pendingDels = nil
+		case line[0] == '+':
+			dl.Type = DiffLineAdd
+			dl.RightIdx = rightIdx
+			rightIdx++
+			if len(pendingDels) > 0 {
+				del := pendingDels[0]
+				pendingDels = pendingDels[1:]
+				if !del.Truncated && !dl.Truncated {
+					annotateWordDiff(del, dl)
+				}
+			}
+		case line[0] == '-':
+			dl.Type = DiffLineDel
+			dl.LeftIdx = leftIdx
+			leftIdx++
+			pendingDels = append(pendingDels, dl)
+		case line[0] == '\\':
+			// "\ No newline at end of file" - a marker about the preceding
+			// line, not content on either side, so it must not advance the
+			// left/right counters.
+			dl.Type = DiffLinePlain
+			dl.LeftIdx, dl.RightIdx = leftIdx, rightIdx
+		default:
+			dl.Type = DiffLinePlain
+			dl.LeftIdx, dl.RightIdx = leftIdx, rightIdx
+			leftIdx++
+			rightIdx++
+			pendingDels = nil
+		}
+
+		currentSection.Lines = append(currentSection.Lines, dl)
+	}
+}
+
+// annotateWordDiff computes a character-level diff between an Add line and
+// the Del line paired with it, and stores the result on both lines
+// so the renderer can highlight only the changed words.
+func annotateWordDiff(del, add *DiffLine) {
+	if len(del.Content) > maxIntralineDiffBytes || len(add.Content) > maxIntralineDiffBytes {
+		return
+	}
+
+	// Strip the leading +/- marker before diffing the actual text.
+	oldText, newText := del.Content, add.Content
+	if len(oldText) > 0 {
+		oldText = oldText[1:]
+	}
+	if len(newText) > 0 {
+		newText = newText[1:]
+	}
+
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMain(oldText, newText, false)
+	diffs = dmp.DiffCleanupSemantic(diffs)
+
+	for _, d := range diffs {
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			del.LeftDiff = append(del.LeftDiff, DiffMatch{Type: DiffMatchEqual, Text: d.Text})
+			add.RightDiff = append(add.RightDiff, DiffMatch{Type: DiffMatchEqual, Text: d.Text})
+		case diffmatchpatch.DiffDelete:
+			del.LeftDiff = append(del.LeftDiff, DiffMatch{Type: DiffMatchDelete, Text: d.Text})
+		case diffmatchpatch.DiffInsert:
+			add.RightDiff = append(add.RightDiff, DiffMatch{Type: DiffMatchInsert, Text: d.Text})
+		}
 	}
 }
 
@@ -308,154 +456,6 @@ func ParseDiffHunkString(diffhunk string) (leftLine, leftHunk, rightLine, righHu
 	return leftLine, leftHunk, rightLine, righHunk
 }
 
-func parseDiff(input *bufio.Reader, currentCommit *Commit, maxFiles int, maxBytes int) error {
-	line, err := input.ReadString('\n')
-	if err != nil {
-		return err
-	}
-	if len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r') {
-		line = line[:len(line)-1]
-	}
-
-	return nil
-}
-
-func parseLog2(out io.Reader, maxBytes int) []*Commit {
-	reader := bufio.NewReader(out)
-
-	var commits []*Commit
-	var currentCommit *Commit
-	var currentFile *File
-	var isContent bool
-	var bytesRead = 0
-	scanNext := true
-
-	for {
-		line, err := reader.ReadString('\n')
-		if scanNext && err == io.EOF {
-			break
-		}
-		scanNext = true
-
-		// new commit found
-		currentFile = nil
-		currentCommit = &Commit{Hash: line[2:], Files: []File{}}
-
-		line, _ = reader.ReadString('\n')
-		line = line[:len(line)-1]
-		currentCommit.AuthorName = line[2:]
-
-		line, _ = reader.ReadString('\n')
-		line = line[:len(line)-1]
-		currentCommit.AuthorEmail = line[2:]
-
-		line, _ = reader.ReadString('\n')
-		line = line[:len(line)-1]
-		currentCommit.Timestamp = line[2:]
-
-		line, _ = reader.ReadString('\n')
-		line = line[:len(line)-1]
-		if line == "" {
-			commits = append(commits, currentCommit)
-			break
-		}
-
-		// if there is no shortstat, it means that the commit is empty, we add it and move onto the next one
-		if line[0] != ' ' {
-			commits = append(commits, currentCommit)
-
-			// avoid scanning the next line, as we already did it
-			scanNext = false
-			continue
-		}
-
-		changed := []byte(line)[1:]
-		changed = bytes.ReplaceAll(changed, []byte("(+)"), []byte(""))
-		changed = bytes.ReplaceAll(changed, []byte("(-)"), []byte(""))
-		currentCommit.Changed = string(changed)
-
-		// twice because --shortstat adds a new line
-		line, _ = reader.ReadString('\n')
-		line = line[:len(line)-1]
-		line, _ = reader.ReadString('\n')
-		line = line[:len(line)-1]
-
-		// commit header parsed
-
-		// files changes inside the commit
-		for {
-			// line := reader.Bytes()
-
-			// end of content of file
-			if len(line) == 0 {
-				isContent = false
-				if currentFile != nil {
-					currentCommit.Files = append(currentCommit.Files, *currentFile)
-				}
-				break
-			}
-
-			// new file found
-			if bytes.HasPrefix([]byte(line), []byte("diff --git")) {
-				// current file is finished, we can add it to the commit
-				if currentFile != nil {
-					currentCommit.Files = append(currentCommit.Files, *currentFile)
-				}
-
-				// create a new file
-				isContent = false
-				bytesRead = 0
-				currentFile = &File{}
-				filenameRegex := regexp.MustCompile(`^diff --git a/(.+) b/(.+)$`)
-				matches := filenameRegex.FindStringSubmatch(string(line))
-				if len(matches) == 3 {
-					currentFile.Filename = matches[2]
-					if matches[1] != matches[2] {
-						currentFile.OldFilename = matches[1]
-					}
-				}
-				line, _ = reader.ReadString('\n')
-				line = line[:len(line)-1]
-				continue
-			}
-
-			if bytes.HasPrefix([]byte(line), []byte("new")) {
-				currentFile.IsCreated = true
-			}
-
-			if bytes.HasPrefix([]byte(line), []byte("deleted")) {
-				currentFile.IsDeleted = true
-			}
-
-			// file content found
-			if line[0] == '@' {
-				isContent = true
-			}
-
-			if isContent {
-				currentFile.Content += string(line) + "\n"
-
-				bytesRead += len(line)
-				if bytesRead > maxBytes {
-					currentFile.Truncated = true
-					currentFile.Content = ""
-					isContent = false
-				}
-			}
-
-			line, _ = reader.ReadString('\n')
-			if len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r') {
-				line = line[:len(line)-1]
-			}
-		}
-
-		commits = append(commits, currentCommit)
-
-	}
-
-	return commits
-}
-
 func ParseCsv(file *File) (*CsvFile, error) {
 
 	reader := csv.NewReader(strings.NewReader(file.Content))
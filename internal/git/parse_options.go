@@ -0,0 +1,17 @@
+package git
+
+// ParseOptions bounds how much of a `git log -p` output parseLog will hold
+// in memory. Each limit is independent and -1 disables it.
+type ParseOptions struct {
+	// MaxFiles caps how many files are parsed per commit.
+	MaxFiles int
+	// MaxLinesPerFile caps how many diff lines are kept per file; once hit,
+	// the rest of that file's diff is skipped and File.Truncated is set.
+	MaxLinesPerFile int
+	// MaxCharsPerLine caps the length of a single diff line; longer lines
+	// are shortened and flagged via DiffLine.Truncated/File.HasTruncatedLine,
+	// parsing then continues with the rest of the file. Independently of
+	// this limit, even when it is disabled, a line longer than parseLog's
+	// 64 KiB read buffer is cut at that size and flagged the same way.
+	MaxCharsPerLine int
+}
@@ -0,0 +1,59 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RawDiffType selects the format GetRawDiff produces.
+type RawDiffType string
+
+const (
+	RawDiffNormal RawDiffType = "diff"
+	RawDiffPatch  RawDiffType = "patch"
+)
+
+// GetRawDiff streams the raw diff or patch for commitID straight to w,
+// without ever holding the whole output in memory. ctx allows the caller to
+// cancel the underlying git subprocess.
+func GetRawDiff(ctx context.Context, repo *Repository, commitID string, t RawDiffType, w io.Writer) error {
+	var args []string
+	switch t {
+	case RawDiffPatch:
+		args = []string{"format-patch", "--stdout", "-1", commitID}
+	default:
+		args = []string{"diff", commitID + "^.." + commitID}
+	}
+	return streamRawDiff(ctx, repo, args, w)
+}
+
+// GetReverseRawDiff streams the reverse diff for commitID (`git show -R`).
+func GetReverseRawDiff(ctx context.Context, repo *Repository, commitID string, w io.Writer) error {
+	return streamRawDiff(ctx, repo, []string{"show", "-R", commitID}, w)
+}
+
+// GetRepoRawDiffForFile streams the diff of a single file between two
+// commits, so the UI can fetch one file's diff without the whole commit.
+// There is no RawDiffType parameter: format-patch only ever describes a
+// single commit, so unlike GetRawDiff there is no "patch between two
+// arbitrary commits, for one file" form to offer - this is always a range
+// diff.
+func GetRepoRawDiffForFile(ctx context.Context, repo *Repository, startCommit, endCommit, path string, w io.Writer) error {
+	args := []string{"diff", startCommit + ".." + endCommit, "--", path}
+	return streamRawDiff(ctx, repo, args, w)
+}
+
+func streamRawDiff(ctx context.Context, repo *Repository, args []string, w io.Writer) error {
+	cmd := repo.gitCommand(ctx, args...)
+	cmd.Stdout = w
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
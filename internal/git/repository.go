@@ -0,0 +1,19 @@
+package git
+
+import (
+	"context"
+	"os/exec"
+)
+
+// Repository is a thin handle on a bare or working git repository on disk.
+type Repository struct {
+	Path string
+}
+
+// gitCommand builds a git subprocess rooted at the repository path, bound to
+// ctx so callers can cancel long-running commands.
+func (r *Repository) gitCommand(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = r.Path
+	return cmd
+}